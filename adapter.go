@@ -2,13 +2,18 @@ package bunadapter
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
 	"github.com/uptrace/bun"
-	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/dialect"
 	"log"
+	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
 )
@@ -17,26 +22,79 @@ type CasbinRule struct {
 	bun.BaseModel `bun:"table:casbin_rule,alias:r"`
 
 	Id    int64  `bun:"id,pk,autoincrement"`
-	Ptype string `bun:"ptype,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
-	V0    string `bun:"v0,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
-	V1    string `bun:"v1,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
-	V2    string `bun:"v2,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
-	V3    string `bun:"v3,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
-	V4    string `bun:"v4,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
-	V5    string `bun:"v5,type:varchar(100),nullzero,notnull,unique:casbin_uidx,default:''"`
+	Ptype string `bun:"ptype,nullzero,notnull,default:''"`
+	V0    string `bun:"v0,nullzero,notnull,default:''"`
+	V1    string `bun:"v1,nullzero,notnull,default:''"`
+	V2    string `bun:"v2,nullzero,notnull,default:''"`
+	V3    string `bun:"v3,nullzero,notnull,default:''"`
+	V4    string `bun:"v4,nullzero,notnull,default:''"`
+	V5    string `bun:"v5,nullzero,notnull,default:''"`
 }
 
+// SaveMode controls how SavePolicy writes the policy to the table.
+type SaveMode int
+
+const (
+	// SaveModeDiff inserts and deletes only the changed rows inside one transaction.
+	SaveModeDiff SaveMode = iota
+	// SaveModeSwap writes the whole policy into a staging table and atomically
+	// swaps it with the live table. Supported on MySQL, PostgreSQL and SQLite.
+	SaveModeSwap
+)
+
+var (
+	_ persist.ContextAdapter          = (*Adapter)(nil)
+	_ persist.ContextFilteredAdapter  = (*Adapter)(nil)
+	_ persist.ContextBatchAdapter     = (*Adapter)(nil)
+	_ persist.ContextUpdatableAdapter = (*Adapter)(nil)
+	_ persist.FilteredAdapter         = (*Adapter)(nil)
+	_ persist.BatchAdapter            = (*Adapter)(nil)
+	_ persist.UpdatableAdapter        = (*Adapter)(nil)
+)
+
+// identRegexp matches the schema and table names the adapter accepts. They
+// are always quoted, so mixed case names keep their case.
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+const (
+	// mysqlMaxKeyLength is the maximum length in bytes of an InnoDB index key.
+	mysqlMaxKeyLength = 3072
+	// mssqlMaxKeyLength is the maximum length in bytes of a SQL Server
+	// nonclustered index key.
+	mssqlMaxKeyLength = 1700
+)
+
 type Adapter struct {
-	ctx       context.Context
-	isFilter  bool
-	db        *bun.DB
-	tableName string
+	ctx         context.Context
+	isFilter    bool
+	db          *bun.DB
+	ownsDB      bool
+	tableName   string
+	schemaName  string
+	saveMode    SaveMode
+	autoCreate  bool
+	finalizer   bool
+	logger      Logger
+	columnWidth int
+	batchSize   int
+	pageSize    int
+	fieldCount  int
+	columnTypes map[string]string
+	customModel Rule
+	rules       *ruleModel
+
+	ignoreDuplicates bool
+	strictUpdates    bool
 }
 
 func (a *Adapter) IsFiltered() bool {
 	return a.isFilter
 }
 
+func (a *Adapter) IsFilteredCtx(ctx context.Context) bool {
+	return a.isFilter
+}
+
 type Filter struct {
 	Ptype []string
 	V0    []string
@@ -45,306 +103,698 @@ type Filter struct {
 	V3    []string
 	V4    []string
 	V5    []string
+	// Values filters the value columns by their index, which reaches the
+	// columns beyond V5 as well.
+	Values map[int][]string
 }
 
-func finalizer(a *Adapter) {
-	if a.db == nil {
-		return
+// SetSaveMode sets how SavePolicy writes the policy, SaveModeDiff by default.
+func (a *Adapter) SetSaveMode(mode SaveMode) {
+	a.saveMode = mode
+}
+
+// Close closes the database connection if the adapter opened it.
+// A *bun.DB passed in by the caller is never closed.
+func (a *Adapter) Close() error {
+	if !a.ownsDB || a.db == nil {
+		return nil
 	}
 
-	err := a.db.Close()
+	runtime.SetFinalizer(a, nil)
+
+	return a.db.Close()
+}
+
+func finalizer(a *Adapter) {
+	err := a.Close()
 	if err != nil {
-		log.Printf("close bun adapter connection failed, err: %v", err)
+		a.logger.Printf("close bun adapter connection failed, err: %v", err)
 	}
 }
 
-func NewAdapterContext(ctx context.Context, db *bun.DB, tableName ...string) (*Adapter, error) {
+// NewAdapterWithOptions creates an adapter for db configured by opts.
+// The adapter never closes db, it stays owned by the caller.
+func NewAdapterWithOptions(db *bun.DB, opts ...Option) (*Adapter, error) {
+	return newAdapter(db, false, opts...)
+}
+
+func newAdapter(db *bun.DB, ownsDB bool, opts ...Option) (*Adapter, error) {
 	a := &Adapter{
-		ctx: ctx,
-		db:  db,
+		ctx:         context.Background(),
+		db:          db,
+		ownsDB:      ownsDB,
+		autoCreate:  true,
+		finalizer:   true,
+		logger:      log.Default(),
+		columnWidth: defaultColumnWidth,
+		batchSize:   defaultBatchSize,
+		pageSize:    defaultPageSize,
+		fieldCount:  defaultFieldCount,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	var rule reflect.Type
+	if a.customModel != nil {
+		rule = reflect.TypeOf(a.customModel)
+		if rule.Kind() != reflect.Ptr || rule.Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf("rule model must be a pointer to a struct, got %s", rule)
+		}
+		rule = rule.Elem()
+		a.fieldCount = valueColumnCount(a.db.Table(rule))
+		if a.fieldCount == 0 {
+			return nil, fmt.Errorf("%s has no v0 column", rule)
+		}
+	} else {
+		err := a.checkColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		if a.db.Dialect().Name() == dialect.MSSQL {
+			a.setDefaultColumnTypes(fmt.Sprintf("nvarchar(%d)", a.columnWidth))
+		}
+		rule = ruleType(a.fieldCount, a.columnTypes)
 	}
 
-	if len(tableName) > 0 {
-		a.tableName = tableName[0]
+	if a.tableName == "" {
+		a.tableName = a.db.Table(rule).Name
+	}
+	if a.schemaName == "" && strings.Contains(a.tableName, ".") {
+		a.schemaName, a.tableName, _ = strings.Cut(a.tableName, ".")
+	}
+	for _, name := range []string{a.schemaName, a.tableName} {
+		if name != "" && !identRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
 	}
 
-	err := a.db.Ping()
+	var err error
+	a.rules, err = newRuleModel(a.db, rule, a.fieldCount, a.columnWidth)
 	if err != nil {
 		return nil, err
 	}
 
-	// create table
-	err = a.createTable()
+	err = a.db.Ping()
 	if err != nil {
-		if pgErr, ok := err.(pgdriver.Error); ok {
-			if pgErr.Field('C') == "42P07" {
-				err = fmt.Errorf("%w\nmaybe anthoer casbin table exists, postgresql is not supported yet", err)
-			}
-		}
 		return nil, err
 	}
 
-	runtime.SetFinalizer(a, finalizer)
+	if a.autoCreate {
+		err = a.translateError(a.createTable())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if a.finalizer && a.ownsDB {
+		runtime.SetFinalizer(a, finalizer)
+	}
 
 	return a, nil
 }
 
+func NewAdapterContext(ctx context.Context, db *bun.DB, tableName ...string) (*Adapter, error) {
+	opts := []Option{WithContext(ctx)}
+	if len(tableName) > 0 && tableName[0] != "" {
+		opts = append(opts, WithTableName(tableName[0]))
+	}
+
+	return NewAdapterWithOptions(db, opts...)
+}
+
 func NewAdapter(db *bun.DB, tableName ...string) (*Adapter, error) {
 	return NewAdapterContext(context.Background(), db, tableName...)
 }
 
-func (a *Adapter) createTable() error {
-	query := a.db.NewCreateTable().Model((*CasbinRule)(nil))
-	if a.tableName != "" {
-		query = query.ModelTableExpr(a.tableName)
+func (a *Adapter) fullTableName() string {
+	if a.schemaName != "" {
+		return a.schemaName + "." + a.tableName
 	}
-	_, err := query.IfNotExists().Exec(a.ctx)
 
-	return err
+	return a.tableName
 }
 
-func (a *Adapter) dropTable() error {
-	query := a.db.NewDropTable().Model((*CasbinRule)(nil))
-	if a.tableName != "" {
-		query = query.ModelTableExpr(a.tableName)
+// newDelete returns a DELETE of rows of table. bun turns it into an UPDATE
+// for a model with a soft delete field, which refers to the table by the r
+// alias, while neither SQL Server nor older MySQL versions accept an alias in
+// a DELETE, so only such a table is aliased.
+func (a *Adapter) newDelete(db bun.IDB, table string) *bun.DeleteQuery {
+	if a.rules.softDelete {
+		return db.NewDelete().Model(a.rules.model()).ModelTableExpr("? AS r", bun.Ident(table))
 	}
-	_, err := query.IfExists().Exec(a.ctx)
 
-	return err
+	return db.NewDelete().Model(a.rules.model()).ModelTableExpr("?", bun.Ident(table))
 }
 
-func loadPolicyLine(line *CasbinRule, model model.Model) {
-	p := []string{line.Ptype, line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}
-	var lineText string
-
-	if line.V5 != "" {
-		lineText = strings.Join(p, ", ")
-	} else if line.V4 != "" {
-		lineText = strings.Join(p[:6], ", ")
-	} else if line.V3 != "" {
-		lineText = strings.Join(p[:5], ", ")
-	} else if line.V2 != "" {
-		lineText = strings.Join(p[:4], ", ")
-	} else if line.V1 != "" {
-		lineText = strings.Join(p[:3], ", ")
-	} else if line.V0 != "" {
-		lineText = strings.Join(p[:2], ", ")
+// newUpdate returns an UPDATE of rows of the table, aliased like newDelete.
+func (a *Adapter) newUpdate(db bun.IDB) *bun.UpdateQuery {
+	if a.rules.softDelete {
+		return db.NewUpdate().Model(a.rules.model()).ModelTableExpr("? AS r", bun.Ident(a.fullTableName()))
 	}
 
-	err := persist.LoadPolicyLine(lineText, model)
-	if err != nil {
-		fmt.Println("load policy line: ", err)
-	}
+	return db.NewUpdate().Model(a.rules.model()).ModelTableExpr("?", bun.Ident(a.fullTableName()))
 }
 
-func (a *Adapter) LoadPolicy(model model.Model) error {
-	lines := make([]*CasbinRule, 0, 64)
+// checkColumnTypes rejects the types WithColumnType set for columns the
+// table does not have.
+func (a *Adapter) checkColumnTypes() error {
+	columns := columnNames(a.fieldCount)
+	for col := range a.columnTypes {
+		found := false
+		for _, name := range columns {
+			if col == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("column type set for unknown column %q, the columns are %s", col, strings.Join(columns, ", "))
+		}
+	}
 
-	query := a.db.NewSelect().Model(&lines)
-	if a.tableName != "" {
-		query = query.ModelTableExpr(fmt.Sprintf("%s AS r", a.tableName))
+	return nil
+}
+
+// setDefaultColumnTypes sets sqlType for the columns WithColumnType left out.
+func (a *Adapter) setDefaultColumnTypes(sqlType string) {
+	if a.columnTypes == nil {
+		a.columnTypes = make(map[string]string)
 	}
-	err := query.Scan(a.ctx)
-	if err != nil {
-		return err
+	for _, col := range columnNames(a.fieldCount) {
+		if a.columnTypes[col] == "" {
+			a.columnTypes[col] = sqlType
+		}
+	}
+}
+
+func (a *Adapter) createTable() error {
+	if a.db.Dialect().Name() == dialect.MSSQL {
+		// SQL Server has no CREATE TABLE IF NOT EXISTS.
+		var id sql.NullInt64
+		quoted := a.db.Formatter().FormatQuery("?", bun.Ident(a.fullTableName()))
+		err := a.db.QueryRowContext(a.ctx, "SELECT OBJECT_ID(?, 'U')", quoted).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if id.Valid {
+			return nil
+		}
 	}
 
-	for _, line := range lines {
-		loadPolicyLine(line, model)
+	query := a.db.NewCreateTable().
+		Model(a.rules.model()).
+		ModelTableExpr("?", bun.Ident(a.fullTableName())).
+		Varchar(a.columnWidth).
+		IfNotExists()
+
+	// A custom model declares its own unique index. Otherwise the index is
+	// named after the table, as PostgreSQL and SQL Server need constraint
+	// names to be unique per schema.
+	uidx := bun.Ident(a.tableName + "_uidx")
+	columns := bun.Safe(strings.Join(a.rules.columns, ", "))
+	switch {
+	case a.hashUniqueIndex() && a.db.Dialect().Name() == dialect.MSSQL:
+		query = query.
+			ColumnExpr("uidx_hash AS CONVERT(CHAR(64), HASHBYTES('SHA2_256', CONCAT(?)), 2) PERSISTED",
+				bun.Safe(strings.Join(a.rules.columns, ", NCHAR(0), "))).
+			ColumnExpr("CONSTRAINT ? UNIQUE (uidx_hash)", uidx)
+	case a.hashUniqueIndex():
+		query = query.
+			ColumnExpr("uidx_hash CHAR(64) AS (SHA2(CONCAT_WS(CHAR(0), ?), 256)) STORED NOT NULL", columns).
+			ColumnExpr("CONSTRAINT ? UNIQUE (uidx_hash)", uidx)
+	case a.customModel == nil:
+		query = query.ColumnExpr("CONSTRAINT ? UNIQUE (?)", uidx, columns)
 	}
 
-	return nil
+	_, err := query.Exec(a.ctx)
+
+	return err
 }
 
-func (a *Adapter) genPolicyLine(ptype string, rule []string) *CasbinRule {
-	line := &CasbinRule{Ptype: ptype}
+// hashUniqueIndex reports whether the unique index covers a generated hash of
+// the columns instead of the columns themselves. MySQL and SQL Server need it
+// for TEXT or varchar(max) columns and for keys longer than they can index.
+func (a *Adapter) hashUniqueIndex() bool {
+	if a.customModel != nil {
+		return false
+	}
 
-	l := len(rule)
-	if l > 0 {
-		line.V0 = rule[0]
+	// utf8mb4 takes up to 4 bytes per character, nvarchar 2 bytes.
+	var charLength, maxKeyLength int
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		charLength, maxKeyLength = 4, mysqlMaxKeyLength
+	case dialect.MSSQL:
+		charLength, maxKeyLength = 2, mssqlMaxKeyLength
+	default:
+		return false
 	}
-	if l > 1 {
-		line.V1 = rule[1]
+
+	keyLength := 0
+	for _, col := range columnNames(a.fieldCount) {
+		width := sqlTypeWidth(a.columnTypes[col], a.columnWidth)
+		if width == 0 {
+			return true
+		}
+		keyLength += charLength * width
 	}
-	if l > 2 {
-		line.V2 = rule[2]
+
+	return keyLength > maxKeyLength
+}
+
+// trimPolicyLine drops the trailing empty values of line, which are the
+// columns the rule does not use.
+func trimPolicyLine(line policyLine) policyLine {
+	n := len(line)
+	for n > 1 && line[n-1] == "" {
+		n--
 	}
-	if l > 3 {
-		line.V3 = rule[3]
+
+	return line[:n]
+}
+
+func (a *Adapter) loadPolicyLine(line policyLine, model model.Model) {
+	line = trimPolicyLine(line)
+	if len(line) == 1 {
+		return
 	}
-	if l > 4 {
-		line.V4 = rule[4]
+
+	err := persist.LoadPolicyArray(line, model)
+	if err != nil {
+		a.logger.Printf("load policy line failed, err: %v", err)
 	}
-	if l > 5 {
-		line.V5 = rule[5]
+}
+
+func (a *Adapter) LoadPolicy(model model.Model) error {
+	return a.LoadPolicyCtx(a.ctx, model)
+}
+
+func (a *Adapter) LoadPolicyCtx(ctx context.Context, model model.Model) error {
+	return a.scanLines(ctx, "1 = 1", nil, func(lines []policyLine) {
+		for _, line := range lines {
+			a.loadPolicyLine(line, model)
+		}
+	})
+}
+
+// genPolicyLine pads rule with empty values up to the value columns of the
+// table. A rule with more values than columns is kept whole for checkLines
+// to reject it.
+func (a *Adapter) genPolicyLine(ptype string, rule []string) policyLine {
+	n := a.fieldCount
+	if len(rule) > n {
+		n = len(rule)
 	}
 
+	line := make(policyLine, 1+n)
+	line[0] = ptype
+	copy(line[1:], rule)
+
 	return line
 }
 
+func ruleKey(line policyLine) string {
+	return strings.Join(line, "\x00")
+}
+
+// SavePolicy applies only the difference between the table and model, in one transaction.
 func (a *Adapter) SavePolicy(model model.Model) error {
-	err := a.dropTable()
+	return a.SavePolicyCtx(a.ctx, model)
+}
+
+// SavePolicyCtx applies only the difference between the table and model, in one transaction.
+func (a *Adapter) SavePolicyCtx(ctx context.Context, model model.Model) error {
+	lines := make([]policyLine, 0, 64)
+	wanted := make(map[string]struct{}, 64)
+
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				line := a.genPolicyLine(ptype, rule)
+				key := ruleKey(line)
+				if _, ok := wanted[key]; ok {
+					continue
+				}
+				wanted[key] = struct{}{}
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	err := a.checkLines(lines...)
 	if err != nil {
 		return err
 	}
 
-	err = a.createTable()
+	if a.saveMode == SaveModeSwap {
+		return a.translateError(a.savePolicySwap(ctx, lines))
+	}
+
+	err = a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		rows := a.rules.newRows(nil)
+		selectQuery := tx.NewSelect().Model(rows).ModelTableExpr("? AS r", bun.Ident(a.fullTableName()))
+		errTx := selectQuery.Scan(ctx)
+		if errTx != nil {
+			return errTx
+		}
+
+		existing, ids := a.rules.lines(rows), a.rules.ids(rows)
+		stale := make([]interface{}, 0)
+		present := make(map[string]struct{}, len(existing))
+		for i, line := range existing {
+			key := ruleKey(line)
+			if _, ok := wanted[key]; !ok {
+				stale = append(stale, ids[i])
+				continue
+			}
+			present[key] = struct{}{}
+		}
+
+		missing := make([]policyLine, 0)
+		for _, line := range lines {
+			if _, ok := present[ruleKey(line)]; !ok {
+				missing = append(missing, line)
+			}
+		}
+
+		errTx = a.deleteIDs(ctx, tx, stale)
+		if errTx != nil {
+			return errTx
+		}
+
+		return a.insertLines(ctx, tx, a.fullTableName(), missing)
+	})
+
+	return a.translateError(err)
+}
+
+func (a *Adapter) savePolicySwap(ctx context.Context, lines []policyLine) error {
+	// Every swap names its staging and old tables with a suffix of its own,
+	// so that concurrent swaps cannot write into or publish each other's
+	// staging table and no table the adapter did not create is dropped.
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
 	if err != nil {
 		return err
 	}
+	table := a.fullTableName()
+	staging := table + "_staging_" + hex.EncodeToString(suffix)
+	old := table + "_old_" + hex.EncodeToString(suffix)
+
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		err = a.createStagingTable(ctx, staging, "CREATE TABLE ? LIKE ?", lines)
+		if err != nil {
+			return err
+		}
+
+		// RENAME TABLE swaps both tables in a single atomic statement.
+		_, err = a.db.ExecContext(ctx, "RENAME TABLE ? TO ?, ? TO ?",
+			bun.Ident(table), bun.Ident(old), bun.Ident(staging), bun.Ident(table))
+		if err != nil {
+			a.dropStagingTable(ctx, staging)
+			return err
+		}
+	case dialect.PG:
+		err = a.createStagingTable(ctx, staging, "CREATE TABLE ? (LIKE ? INCLUDING ALL)", lines)
+		if err != nil {
+			return err
+		}
+
+		// The staging table shares the id sequence of the live table, so the
+		// sequence changes hands before the old table is dropped.
+		err = a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+			var seq sql.NullString
+			quoted := a.db.Formatter().FormatQuery("?", bun.Ident(table))
+			errTx := tx.QueryRowContext(ctx, "SELECT pg_get_serial_sequence(?, ?)", quoted, a.rules.pk).Scan(&seq)
+			if errTx != nil {
+				return errTx
+			}
 
-	lines := make([]*CasbinRule, 0, 64)
+			_, errTx = tx.ExecContext(ctx, "ALTER TABLE ? RENAME TO ?", bun.Ident(table), bun.Ident(unqualified(old)))
+			if errTx != nil {
+				return errTx
+			}
+			_, errTx = tx.ExecContext(ctx, "ALTER TABLE ? RENAME TO ?", bun.Ident(staging), bun.Ident(unqualified(table)))
+			if errTx != nil {
+				return errTx
+			}
+			if seq.Valid {
+				_, errTx = tx.ExecContext(ctx, "ALTER SEQUENCE ? OWNED BY ?", bun.Safe(seq.String), bun.Ident(table+"."+a.rules.pk))
+				if errTx != nil {
+					return errTx
+				}
+			}
 
-	for ptype, ast := range model["p"] {
-		for _, rule := range ast.Policy {
-			line := a.genPolicyLine(ptype, rule)
-			lines = append(lines, line)
+			return nil
+		})
+		if err != nil {
+			a.dropStagingTable(ctx, staging)
+			return err
 		}
+	case dialect.SQLite:
+		// SQLite has no CREATE TABLE ... LIKE. As a write transaction locks the
+		// whole database anyway, the table is rewritten in one transaction.
+		return a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+			_, errTx := a.newDelete(tx, table).Where("1 = 1").Exec(ctx)
+			if errTx != nil {
+				return errTx
+			}
+
+			return a.insertLines(ctx, tx, table, lines)
+		})
+	default:
+		return fmt.Errorf("save mode swap is not supported by %s", a.db.Dialect().Name())
 	}
 
-	for ptype, ast := range model["g"] {
-		for _, rule := range ast.Policy {
-			line := a.genPolicyLine(ptype, rule)
-			lines = append(lines, line)
-		}
+	// The new policy is live by now, so a failed drop only leaves the old
+	// table behind.
+	_, err = a.db.ExecContext(ctx, "DROP TABLE ?", bun.Ident(old))
+	if err != nil {
+		a.logger.Printf("drop old table %s failed, err: %v", old, err)
 	}
 
-	if len(lines) == 0 {
-		return nil
+	return nil
+}
+
+// createStagingTable creates the staging table like the live table and writes
+// lines into it.
+func (a *Adapter) createStagingTable(ctx context.Context, staging, createQuery string, lines []policyLine) error {
+	_, err := a.db.ExecContext(ctx, createQuery, bun.Ident(staging), bun.Ident(a.fullTableName()))
+	if err != nil {
+		return err
 	}
 
-	query := a.db.NewInsert().Model(&lines)
-	if a.tableName != "" {
-		query = query.ModelTableExpr(a.tableName)
+	err = a.writeLines(ctx, staging, lines)
+	if err != nil {
+		a.dropStagingTable(ctx, staging)
 	}
-	_, err = query.Exec(a.ctx)
 
 	return err
 }
 
+func (a *Adapter) dropStagingTable(ctx context.Context, staging string) {
+	_, err := a.db.ExecContext(ctx, "DROP TABLE IF EXISTS ?", bun.Ident(staging))
+	if err != nil {
+		a.logger.Printf("drop staging table %s failed, err: %v", staging, err)
+	}
+}
+
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
 func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.AddPolicyCtx(a.ctx, sec, ptype, rule)
+}
+
+func (a *Adapter) AddPolicyCtx(ctx context.Context, sec string, ptype string, rule []string) error {
 	line := a.genPolicyLine(ptype, rule)
-	query := a.db.NewInsert().Model(line)
-	if a.tableName != "" {
-		query = query.ModelTableExpr(a.tableName)
+	err := a.checkLines(line)
+	if err != nil {
+		return err
+	}
+
+	query := a.db.NewInsert().Model(a.rules.newRow(line)).ModelTableExpr("?", bun.Ident(a.fullTableName()))
+	if a.ignoreDuplicates {
+		query = query.Ignore()
+	}
+	_, err = query.Exec(ctx)
+
+	// Ignore does nothing on SQL Server.
+	err = a.translateError(err)
+	if a.ignoreDuplicates && errors.Is(err, ErrDuplicateRule) {
+		return nil
 	}
-	_, err := query.Exec(a.ctx)
 
 	return err
 }
 
 func (a *Adapter) AddPolicies(sec, ptype string, rules [][]string) error {
-	err := a.db.RunInTx(a.ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
-		for _, rule := range rules {
-			line := a.genPolicyLine(ptype, rule)
-			query := tx.NewInsert().Model(line)
-			if a.tableName != "" {
-				query = query.ModelTableExpr(a.tableName)
-			}
-			_, errTx := query.Exec(ctx)
-			if errTx != nil {
-				return errTx
-			}
+	return a.AddPoliciesCtx(a.ctx, sec, ptype, rules)
+}
+
+func (a *Adapter) AddPoliciesCtx(ctx context.Context, sec, ptype string, rules [][]string) error {
+	lines := make([]policyLine, 0, len(rules))
+	for _, rule := range rules {
+		lines = append(lines, a.genPolicyLine(ptype, rule))
+	}
+
+	err := a.checkLines(lines...)
+	if err != nil {
+		return err
+	}
+
+	return a.translateError(a.writeLines(ctx, a.fullTableName(), lines))
+}
+
+// AddPoliciesInserted adds rules like AddPolicies and returns the ones that
+// were not stored yet. Rules that are already stored are left out instead of
+// failing with ErrDuplicateRule, so that the caller can add rules
+// idempotently and still learn which of them are new.
+func (a *Adapter) AddPoliciesInserted(sec, ptype string, rules [][]string) ([][]string, error) {
+	return a.AddPoliciesInsertedCtx(a.ctx, sec, ptype, rules)
+}
+
+// AddPoliciesInsertedCtx adds rules like AddPoliciesCtx and returns the ones
+// that were not stored yet.
+func (a *Adapter) AddPoliciesInsertedCtx(ctx context.Context, sec, ptype string, rules [][]string) ([][]string, error) {
+	lines := make([]policyLine, 0, len(rules))
+	unique := make(map[string][]string, len(rules))
+	for _, rule := range rules {
+		line := a.genPolicyLine(ptype, rule)
+		key := ruleKey(line)
+		if _, ok := unique[key]; ok {
+			continue
 		}
+		unique[key] = rule
+		lines = append(lines, line)
+	}
 
-		return nil
+	err := a.checkLines(lines...)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []policyLine
+	err = a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// The rules stored already are left out beforehand as well, as a
+		// custom model may have no unique index for the INSERT to hit.
+		missing, errTx := a.newLines(ctx, tx, lines)
+		if errTx != nil {
+			return errTx
+		}
+
+		written, errTx = a.insertNewLines(ctx, tx, missing)
+		return errTx
 	})
+	if err != nil {
+		return nil, a.translateError(err)
+	}
 
-	return err
+	inserted := make([][]string, 0, len(written))
+	for _, line := range written {
+		inserted = append(inserted, unique[ruleKey(line)])
+	}
+
+	return inserted, nil
 }
 
-func genWhereCondition(line *CasbinRule) (string, []interface{}) {
-	cond := "ptype = ? AND v0 = ? AND v1 = ? AND v2 = ? AND v3 = ? AND v4 = ? AND v5 = ?"
-	args := []interface{}{
-		line.Ptype,
-		line.V0,
-		line.V1,
-		line.V2,
-		line.V3,
-		line.V4,
-		line.V5,
+func (a *Adapter) genWhereCondition(line policyLine) (string, []interface{}) {
+	clauseSlice := make([]string, 0, len(line))
+	args := make([]interface{}, 0, len(line))
+	for i, col := range a.rules.columns {
+		clauseSlice = append(clauseSlice, fmt.Sprintf("%s = ?", col))
+		args = append(args, line[i])
 	}
 
-	return cond, args
+	return strings.Join(clauseSlice, " AND "), args
 }
 
 func (a *Adapter) RemovePolicy(set, ptype string, rule []string) error {
+	return a.RemovePolicyCtx(a.ctx, set, ptype, rule)
+}
+
+func (a *Adapter) RemovePolicyCtx(ctx context.Context, set, ptype string, rule []string) error {
 	line := a.genPolicyLine(ptype, rule)
-	clause, args := genWhereCondition(line)
-	query := a.db.NewDelete().Model(line)
-	if a.tableName != "" {
-		query.ModelTableExpr(a.tableName)
+	err := a.checkLines(line)
+	if err != nil {
+		return err
 	}
 
-	_, err := query.Where(clause, args...).Exec(a.ctx)
-	return err
-}
+	clause, args := a.genWhereCondition(line)
+	query := a.newDelete(a.db, a.fullTableName())
 
-func (a *Adapter) RemovePolicies(sec, ptype string, rules [][]string) error {
-	err := a.db.RunInTx(a.ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
-		for _, rule := range rules {
-			line := a.genPolicyLine(ptype, rule)
-			clause, args := genWhereCondition(line)
-			query := tx.NewDelete().Model(line)
-			if a.tableName != "" {
-				query.ModelTableExpr(a.tableName)
-			}
+	res, err := query.Where(clause, args...).Exec(ctx)
+	if err != nil {
+		return a.translateError(err)
+	}
 
-			_, errTx := query.Where(clause, args...).Exec(ctx)
-			if errTx != nil {
-				return errTx
-			}
-		}
+	return checkAffected(res, rule)
+}
 
-		return nil
-	})
+func (a *Adapter) RemovePolicies(sec, ptype string, rules [][]string) error {
+	return a.RemovePoliciesCtx(a.ctx, sec, ptype, rules)
+}
 
+func (a *Adapter) RemovePoliciesCtx(ctx context.Context, sec, ptype string, rules [][]string) error {
+	_, err := a.RemovePoliciesCountCtx(ctx, sec, ptype, rules)
 	return err
 }
 
-func (a *Adapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
-	filterValue, ok := filter.(*Filter)
-	if !ok {
-		return fmt.Errorf("invalid filter type")
-	}
+// RemovePoliciesCount removes rules in a few set-based DELETE statements inside
+// one transaction and returns how many rows were actually removed.
+func (a *Adapter) RemovePoliciesCount(sec, ptype string, rules [][]string) (int64, error) {
+	return a.RemovePoliciesCountCtx(a.ctx, sec, ptype, rules)
+}
 
-	lines := make([]*CasbinRule, 0, 64)
-	fields := [7]struct {
-		col string
-		val []string
-	}{
-		{"ptype", filterValue.Ptype},
-		{"v0", filterValue.V0},
-		{"v1", filterValue.V1},
-		{"v2", filterValue.V2},
-		{"v3", filterValue.V3},
-		{"v4", filterValue.V4},
-		{"v5", filterValue.V5},
+// RemovePoliciesCountCtx removes rules like RemovePoliciesCount.
+func (a *Adapter) RemovePoliciesCountCtx(ctx context.Context, sec, ptype string, rules [][]string) (int64, error) {
+	lines := make([]policyLine, 0, len(rules))
+	for _, rule := range rules {
+		lines = append(lines, a.genPolicyLine(ptype, rule))
 	}
 
-	query := a.db.NewSelect().Model(&lines)
-	if a.tableName != "" {
-		query = query.ModelTableExpr(fmt.Sprintf("%s AS r", a.tableName))
+	err := a.checkLines(lines...)
+	if err != nil {
+		return 0, err
 	}
 
-	for i := range fields {
-		switch len(fields[i].val) {
-		case 0:
-			continue
-		case 1:
-			query = query.Where("? = ?", bun.Ident(fields[i].col), fields[i].val[0])
-		default:
-			query = query.Where("? IN (?)", bun.Ident(fields[i].col), bun.In(fields[i].val))
-		}
+	var removed int64
+	err = a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		var errTx error
+		removed, errTx = a.deleteLines(ctx, tx, lines)
+		return errTx
+	})
+	if err != nil {
+		return 0, a.translateError(err)
 	}
 
-	err := query.Scan(a.ctx)
+	return removed, nil
+}
+
+func (a *Adapter) LoadFilteredPolicy(model model.Model, filter interface{}) error {
+	return a.LoadFilteredPolicyCtx(a.ctx, model, filter)
+}
+
+// LoadFilteredPolicyCtx loads the rules matched by filter, a *Filter, a
+// Predicate, Predicates, a PtypeFilter or an expression built with And, Or
+// and Not.
+func (a *Adapter) LoadFilteredPolicyCtx(ctx context.Context, model model.Model, filter interface{}) error {
+	cond, args, err := a.filterCondition(filter)
 	if err != nil {
 		return err
 	}
 
-	for _, line := range lines {
-		loadPolicyLine(line, model)
+	err = a.scanLines(ctx, cond, args, func(lines []policyLine) {
+		for _, line := range lines {
+			a.loadPolicyLine(line, model)
+		}
+	})
+	if err != nil {
+		return err
 	}
 
 	a.isFilter = true
@@ -352,107 +802,112 @@ func (a *Adapter) LoadFilteredPolicy(model model.Model, filter interface{}) erro
 	return nil
 }
 
-func genFilteredWhereCondition(line *CasbinRule) (string, []interface{}) {
+// LoadIncrementalFilteredPolicy adds the rules matched by filter to the rules
+// model already holds, skipping the ones it has, so that the rules of a tenant
+// can be loaded the first time the tenant is seen.
+func (a *Adapter) LoadIncrementalFilteredPolicy(model model.Model, filter interface{}) error {
+	return a.LoadIncrementalFilteredPolicyCtx(a.ctx, model, filter)
+}
+
+// LoadIncrementalFilteredPolicyCtx adds the rules matched by filter to the
+// rules model already holds, skipping the ones it has.
+func (a *Adapter) LoadIncrementalFilteredPolicyCtx(ctx context.Context, model model.Model, filter interface{}) error {
+	// LoadFilteredPolicy never clears the model, which the enforcer does
+	// instead, and LoadPolicyArray skips the rules the model holds.
+	return a.LoadFilteredPolicyCtx(ctx, model, filter)
+}
+
+func (a *Adapter) genFilteredWhereCondition(line policyLine) (string, []interface{}) {
 	var clauseSlice []string
 	var args []interface{}
 
-	fields := [7]struct {
-		col string
-		val string
-	}{
-		{"ptype", line.Ptype},
-		{"v0", line.V0},
-		{"v1", line.V1},
-		{"v2", line.V2},
-		{"v3", line.V3},
-		{"v4", line.V4},
-		{"v5", line.V5},
+	for i, col := range a.rules.columns {
+		if line[i] != "" {
+			clauseSlice = append(clauseSlice, fmt.Sprintf("%s = ?", col))
+			args = append(args, line[i])
+		}
 	}
 
-	for i := range fields {
-		if fields[i].val != "" {
-			clauseSlice = append(clauseSlice, fmt.Sprintf("%s = ?", fields[i].col))
-			args = append(args, fields[i].val)
+	return strings.Join(clauseSlice, " AND "), args
+}
+
+// genFilteredLine returns the line matched by RemoveFilteredPolicy and
+// UpdateFilteredPolicies, where an empty value matches anything.
+func (a *Adapter) genFilteredLine(ptype string, fieldIndex int, fieldValues ...string) (policyLine, error) {
+	line := make(policyLine, 1+a.fieldCount)
+	line[0] = ptype
+
+	for i, val := range fieldValues {
+		idx := fieldIndex + i
+		if idx < 0 || val == "" {
+			continue
 		}
+		if idx >= a.fieldCount {
+			return nil, fmt.Errorf("%w: filter on v%d, the table has %d value columns", ErrTooManyFields, idx, a.fieldCount)
+		}
+		line[1+idx] = val
 	}
 
-	return strings.Join(clauseSlice, " AND "), args
+	return line, nil
 }
 
 func (a *Adapter) RemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
-	line := &CasbinRule{Ptype: ptype}
+	return a.RemoveFilteredPolicyCtx(a.ctx, sec, ptype, fieldIndex, fieldValues...)
+}
 
-	idx := fieldIndex + len(fieldValues)
-	if fieldIndex <= 0 && idx > 0 {
-		line.V0 = fieldValues[0-fieldIndex]
-	}
-	if fieldIndex <= 1 && idx > 1 {
-		line.V1 = fieldValues[1-fieldIndex]
-	}
-	if fieldIndex <= 2 && idx > 2 {
-		line.V2 = fieldValues[2-fieldIndex]
-	}
-	if fieldIndex <= 3 && idx > 3 {
-		line.V3 = fieldValues[3-fieldIndex]
-	}
-	if fieldIndex <= 4 && idx > 4 {
-		line.V4 = fieldValues[4-fieldIndex]
-	}
-	if fieldIndex <= 5 && idx > 5 {
-		line.V5 = fieldValues[5-fieldIndex]
+func (a *Adapter) RemoveFilteredPolicyCtx(ctx context.Context, sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	line, err := a.genFilteredLine(ptype, fieldIndex, fieldValues...)
+	if err != nil {
+		return err
 	}
 
-	query := a.db.NewDelete().Model(line)
-	if a.tableName != "" {
-		query = query.ModelTableExpr(a.tableName)
-	}
-	clause, args := genFilteredWhereCondition(line)
-	_, err := query.Where(clause, args...).Exec(a.ctx)
+	query := a.newDelete(a.db, a.fullTableName())
+	clause, args := a.genFilteredWhereCondition(line)
+	_, err = query.Where(clause, args...).Exec(ctx)
 
-	return err
+	return a.translateError(err)
 }
 
 func (a *Adapter) UpdatePolicy(sec, ptype string, oldRule, newRule []string) error {
+	return a.UpdatePolicyCtx(a.ctx, sec, ptype, oldRule, newRule)
+}
+
+func (a *Adapter) UpdatePolicyCtx(ctx context.Context, sec, ptype string, oldRule, newRule []string) error {
 	oRule := a.genPolicyLine(ptype, oldRule)
 	nRule := a.genPolicyLine(ptype, newRule)
+	err := a.checkLines(oRule, nRule)
+	if err != nil {
+		return err
+	}
 
-	query := a.db.NewUpdate().Model(nRule)
-	if a.tableName != "" {
-		query = query.ModelTableExpr(a.tableName)
-	}
-	clause, args := genWhereCondition(oRule)
-	_, err := query.
-		Set("ptype = ?", nRule.Ptype).
-		Set("v0 = ?", nRule.V0).
-		Set("v1 = ?", nRule.V1).
-		Set("v2 = ?", nRule.V2).
-		Set("v3 = ?", nRule.V3).
-		Set("v4 = ?", nRule.V4).
-		Set("v5 = ?", nRule.V5).
-		Where(clause, args...).Exec(a.ctx)
-
-	return err
+	return a.translateError(a.updateLine(ctx, a.db, oRule, nRule, oldRule))
 }
 
 func (a *Adapter) UpdatePolicies(sec, ptype string, oldRules, newRules [][]string) error {
-	err := a.db.RunInTx(a.ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+	return a.UpdatePoliciesCtx(a.ctx, sec, ptype, oldRules, newRules)
+}
+
+// UpdatePoliciesCtx replaces oldRules with newRules in one transaction. An old
+// rule that is not stored makes it roll back and return ErrRuleNotFound with
+// WithStrictUpdates. Otherwise the rules that were found are updated and the
+// missing ones are only logged, as the enforcer applies none of the updates to
+// its model once the adapter returns an error.
+func (a *Adapter) UpdatePoliciesCtx(ctx context.Context, sec, ptype string, oldRules, newRules [][]string) error {
+	var notFound []error
+	err := a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		notFound = notFound[:0]
 		for i, oldRule := range oldRules {
 			nRule, oRule := a.genPolicyLine(ptype, newRules[i]), a.genPolicyLine(ptype, oldRule)
-			query := tx.NewUpdate().Model(nRule)
-			if a.tableName != "" {
-				query = query.ModelTableExpr(a.tableName)
+			errTx := a.checkLines(oRule, nRule)
+			if errTx != nil {
+				return errTx
 			}
-			clause, args := genWhereCondition(oRule)
-			_, errTx := query.
-				Set("ptype = ?", nRule.Ptype).
-				Set("v0 = ?", nRule.V0).
-				Set("v1 = ?", nRule.V1).
-				Set("v2 = ?", nRule.V2).
-				Set("v3 = ?", nRule.V3).
-				Set("v4 = ?", nRule.V4).
-				Set("v5 = ?", nRule.V5).
-				Where(clause, args...).Exec(ctx)
 
+			errTx = a.updateLine(ctx, tx, oRule, nRule, oldRule)
+			if errors.Is(errTx, ErrRuleNotFound) && !a.strictUpdates {
+				notFound = append(notFound, errTx)
+				continue
+			}
 			if errTx != nil {
 				return errTx
 			}
@@ -460,8 +915,48 @@ func (a *Adapter) UpdatePolicies(sec, ptype string, oldRules, newRules [][]strin
 
 		return nil
 	})
+	if err != nil {
+		return a.translateError(err)
+	}
 
-	return err
+	for _, err := range notFound {
+		a.logger.Printf("update policy failed, err: %v", err)
+	}
+
+	return nil
+}
+
+// updateLine replaces oRule with nRule and returns ErrRuleNotFound if oRule is
+// not stored.
+func (a *Adapter) updateLine(ctx context.Context, db bun.IDB, oRule, nRule policyLine, oldRule []string) error {
+	clause, args := a.genWhereCondition(oRule)
+
+	// MySQL only counts the rows that actually changed, so an update that
+	// keeps the rule as it is would always look like a miss.
+	if ruleKey(oRule) == ruleKey(nRule) {
+		exists, err := db.NewSelect().Model(a.rules.model()).ModelTableExpr("? AS r", bun.Ident(a.fullTableName())).
+			Where(clause, args...).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %v", ErrRuleNotFound, oldRule)
+		}
+
+		return nil
+	}
+
+	query := a.newUpdate(db)
+	for i, col := range a.rules.columns {
+		query = query.Set(fmt.Sprintf("%s = ?", col), nRule[i])
+	}
+
+	res, err := query.Where(clause, args...).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res, oldRule)
 }
 
 func (a *Adapter) UpdateFilteredPolicies(
@@ -471,72 +966,62 @@ func (a *Adapter) UpdateFilteredPolicies(
 	fieldIndex int,
 	fieldValues ...string,
 ) ([][]string, error) {
-	line := &CasbinRule{Ptype: ptype}
-	if fieldIndex <= 0 && 0 < fieldIndex+len(fieldValues) {
-		line.V0 = fieldValues[0-fieldIndex]
-	}
-	if fieldIndex <= 1 && 1 < fieldIndex+len(fieldValues) {
-		line.V1 = fieldValues[1-fieldIndex]
-	}
-	if fieldIndex <= 2 && 2 < fieldIndex+len(fieldValues) {
-		line.V2 = fieldValues[2-fieldIndex]
-	}
-	if fieldIndex <= 3 && 3 < fieldIndex+len(fieldValues) {
-		line.V3 = fieldValues[3-fieldIndex]
-	}
-	if fieldIndex <= 4 && 4 < fieldIndex+len(fieldValues) {
-		line.V4 = fieldValues[4-fieldIndex]
-	}
-	if fieldIndex <= 5 && 5 < fieldIndex+len(fieldValues) {
-		line.V5 = fieldValues[5-fieldIndex]
+	return a.UpdateFilteredPoliciesCtx(a.ctx, sec, ptype, newRules, fieldIndex, fieldValues...)
+}
+
+func (a *Adapter) UpdateFilteredPoliciesCtx(
+	ctx context.Context,
+	sec string,
+	ptype string,
+	newRules [][]string,
+	fieldIndex int,
+	fieldValues ...string,
+) ([][]string, error) {
+	line, err := a.genFilteredLine(ptype, fieldIndex, fieldValues...)
+	if err != nil {
+		return nil, err
 	}
 
-	newR := make([]*CasbinRule, 0, len(newRules))
-	oldR := make([]*CasbinRule, 0)
+	newR := make([]policyLine, 0, len(newRules))
+	oldR := a.rules.newRows(nil)
 	for _, newRule := range newRules {
 		newR = append(newR, a.genPolicyLine(ptype, newRule))
 	}
+	err = a.checkLines(newR...)
+	if err != nil {
+		return nil, err
+	}
 
-	err := a.db.RunInTx(a.ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+	err = a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
 		var (
 			errTx error
 		)
 
-		selectQuery := tx.NewSelect().Model(&oldR)
-		if a.tableName != "" {
-			selectQuery = selectQuery.ModelTableExpr(fmt.Sprintf("%s AS r", a.tableName))
-		}
-		clause, args := genFilteredWhereCondition(line)
+		selectQuery := tx.NewSelect().Model(oldR).ModelTableExpr("? AS r", bun.Ident(a.fullTableName()))
+		clause, args := a.genFilteredWhereCondition(line)
 		errTx = selectQuery.Where(clause, args...).Scan(ctx)
 		if errTx != nil {
 			return errTx
 		}
 
-		deleteQuery := tx.NewDelete().Model(&oldR)
-		if a.tableName != "" {
-			deleteQuery = deleteQuery.ModelTableExpr(fmt.Sprintf("%s AS r", a.tableName))
-		}
-		_, errTx = deleteQuery.WherePK().Exec(ctx)
+		// Neither SQL Server nor older MySQL versions accept the table alias
+		// WherePK needs in a DELETE, so the rows are deleted by their ids.
+		errTx = a.deleteIDs(ctx, tx, a.rules.ids(oldR))
 		if errTx != nil {
 			return errTx
 		}
 
-		insertQuery := tx.NewInsert().Model(&newR)
-		if a.tableName != "" {
-			insertQuery = insertQuery.ModelTableExpr(a.tableName)
-		}
-		_, errTx = insertQuery.Exec(ctx)
-		if errTx != nil {
-			return errTx
+		if len(newR) == 0 {
+			return nil
 		}
 
-		return nil
+		return a.insertLines(ctx, tx, a.fullTableName(), newR)
 	})
 
 	oldPolicies := make([][]string, 0)
-	for _, rule := range oldR {
-		oldPolicies = append(oldPolicies, []string{rule.Ptype, rule.V0, rule.V1, rule.V2, rule.V3, rule.V4, rule.V5})
+	for _, rule := range a.rules.lines(oldR) {
+		oldPolicies = append(oldPolicies, rule)
 	}
 
-	return oldPolicies, err
+	return oldPolicies, a.translateError(err)
 }
@@ -0,0 +1,363 @@
+// Package adaptertest checks that a casbin adapter meets the contract of
+// persist.Adapter and of its batch, updatable and filtered extensions, so
+// that adapters wrapping this one can reuse its tests.
+package adaptertest
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+const rbacModel = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+// initialPolicy is stored by every test before it starts.
+var initialPolicy = [][]string{
+	{"p", "alice", "data1", "read"},
+	{"p", "bob", "data2", "write"},
+	{"p", "data2_admin", "data2", "read"},
+	{"p", "data2_admin", "data2", "write"},
+	{"g", "alice", "data2_admin"},
+}
+
+// Suite configures the conformance tests.
+type Suite struct {
+	// NewAdapter returns an adapter over an empty policy storage. It is called
+	// once per test, so that the tests do not see each other's rules.
+	NewAdapter func(t *testing.T) persist.Adapter
+	// Filter returns a filter for LoadFilteredPolicy that selects the rules
+	// whose first value is one of subjects. The filtered tests are skipped
+	// without it.
+	Filter func(subjects ...string) interface{}
+	// ColumnsFilter returns a filter for LoadFilteredPolicy that selects the
+	// rules whose ptype is one of ptypes and whose value at index i is one of
+	// values[i], for every list that is not empty. The lists are ANDed. The
+	// test of filters over several columns is skipped without it.
+	ColumnsFilter func(ptypes []string, values ...[]string) interface{}
+}
+
+// Run runs the conformance tests as subtests of t. The tests of an optional
+// interface, like persist.BatchAdapter, are skipped for an adapter that does
+// not implement it.
+func Run(t *testing.T, s Suite) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Suite)
+	}{
+		{"SaveLoad", testSaveLoad},
+		{"AddPolicy", testAddPolicy},
+		{"AutoSave", testAutoSave},
+		{"BatchAutoSave", testBatchAutoSave},
+		{"FilteredPolicy", testFilteredPolicy},
+		{"FilteredPolicyColumns", testFilteredPolicyColumns},
+		{"UpdatePolicy", testUpdatePolicy},
+		{"UpdatePolicies", testUpdatePolicies},
+		{"UpdateFilteredPolicies", testUpdateFilteredPolicies},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, s)
+		})
+	}
+}
+
+func newModel(t *testing.T) model.Model {
+	t.Helper()
+	m, err := model.NewModelFromString(rbacModel)
+	if err != nil {
+		t.Fatalf("NewModelFromString failed, err: %v", err)
+	}
+
+	return m
+}
+
+// newEnforcer stores the initial policy with a new adapter and returns an
+// enforcer that has loaded it back.
+func newEnforcer(t *testing.T, s Suite) (*casbin.Enforcer, persist.Adapter) {
+	t.Helper()
+	a := s.NewAdapter(t)
+
+	m := newModel(t)
+	for _, rule := range initialPolicy {
+		// The section of a ptype is its first letter, p for p2 and g for g2.
+		err := m.AddPolicy(rule[0][:1], rule[0], rule[1:])
+		if err != nil {
+			t.Fatalf("AddPolicy %v failed, err: %v", rule, err)
+		}
+	}
+	err := a.SavePolicy(m)
+	if err != nil {
+		t.Fatalf("SavePolicy failed, err: %v", err)
+	}
+
+	e, err := casbin.NewEnforcer(newModel(t), a)
+	if err != nil {
+		t.Fatalf("NewEnforcer failed, err: %v", err)
+	}
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	return e, a
+}
+
+// checkPolicy compares the p rules of e with want, in any order.
+func checkPolicy(t *testing.T, e *casbin.Enforcer, want [][]string) {
+	t.Helper()
+	got, err := e.GetPolicy()
+	if err != nil {
+		t.Fatalf("GetPolicy failed, err: %v", err)
+	}
+
+	if !equalPolicies(got, want) {
+		t.Errorf("Policy: %v\nsupposed to be %v", got, want)
+	}
+}
+
+func equalPolicies(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	keys := func(rules [][]string) []string {
+		keys := make([]string, 0, len(rules))
+		for _, rule := range rules {
+			keys = append(keys, strings.Join(rule, "\x00"))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	keysA, keysB := keys(a), keys(b)
+	for i := range keysA {
+		if keysA[i] != keysB[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkErr(t *testing.T, action string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("%s failed, err: %v", action, err)
+	}
+}
+
+func testSaveLoad(t *testing.T, s Suite) {
+	e, _ := newEnforcer(t, s)
+
+	err := e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	ok, err := e.Enforce("alice", "data2", "read")
+	checkErr(t, "Enforce", err)
+	if !ok {
+		t.Errorf("alice should read data2 through the loaded role")
+	}
+}
+
+func testAddPolicy(t *testing.T, s Suite) {
+	e, _ := newEnforcer(t, s)
+
+	_, err := e.AddPolicy("bob", "data1", "write")
+	checkErr(t, "AddPolicy", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data1", "write"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func testAutoSave(t *testing.T, s Suite) {
+	e, _ := newEnforcer(t, s)
+	e.EnableAutoSave(false)
+
+	// Because AutoSave is disabled, the policy change only affects the policy in Casbin enforcer,
+	// it doesn't affect the policy in the storage.
+	_, err := e.AddPolicy("alice", "data1", "write")
+	checkErr(t, "AddPolicy", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	// Because AutoSave is enabled, the policy change not only affects the policy in Casbin enforcer,
+	// but also affects the policy in the storage.
+	e.EnableAutoSave(true)
+	_, err = e.AddPolicy("alice", "data1", "write")
+	checkErr(t, "AddPolicy", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}, {"alice", "data1", "write"}})
+
+	_, err = e.RemovePolicy("alice", "data1", "write")
+	checkErr(t, "RemovePolicy", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	// Two rules: {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"} are deleted.
+	_, err = e.RemoveFilteredPolicy(0, "data2_admin")
+	checkErr(t, "RemoveFilteredPolicy", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+}
+
+func testBatchAutoSave(t *testing.T, s Suite) {
+	e, a := newEnforcer(t, s)
+	if _, ok := a.(persist.BatchAdapter); !ok {
+		t.Skip("adapter is not a persist.BatchAdapter")
+	}
+
+	e.EnableAutoSave(false)
+	_, err := e.AddPolicies([][]string{{"alice_1", "data_1", "read_1"}, {"bob_1", "data_1", "write_1"}})
+	checkErr(t, "AddPolicies", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	e.EnableAutoSave(true)
+	_, err = e.AddPolicies([][]string{{"alice_2", "data_2", "read_2"}, {"bob_2", "data_2", "write_2"}})
+	checkErr(t, "AddPolicies", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"},
+		{"alice_2", "data_2", "read_2"}, {"bob_2", "data_2", "write_2"}})
+
+	_, err = e.RemovePolicies([][]string{{"alice_2", "data_2", "read_2"}, {"bob_2", "data_2", "write_2"}})
+	checkErr(t, "RemovePolicies", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func testFilteredPolicy(t *testing.T, s Suite) {
+	if s.Filter == nil {
+		t.Skip("Suite.Filter is not set")
+	}
+	e, a := newEnforcer(t, s)
+	if _, ok := a.(persist.FilteredAdapter); !ok {
+		t.Skip("adapter is not a persist.FilteredAdapter")
+	}
+
+	err := e.LoadFilteredPolicy(s.Filter("alice"))
+	checkErr(t, "LoadFilteredPolicy alice", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}})
+	if !e.IsFiltered() {
+		t.Errorf("enforcer should be filtered after LoadFilteredPolicy")
+	}
+
+	err = e.LoadFilteredPolicy(s.Filter("bob"))
+	checkErr(t, "LoadFilteredPolicy bob", err)
+	checkPolicy(t, e, [][]string{{"bob", "data2", "write"}})
+
+	err = e.LoadFilteredPolicy(s.Filter("data2_admin"))
+	checkErr(t, "LoadFilteredPolicy data2_admin", err)
+	checkPolicy(t, e, [][]string{{"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	err = e.LoadFilteredPolicy(s.Filter("alice", "bob"))
+	checkErr(t, "LoadFilteredPolicy alice bob", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+
+	_, err = e.AddPolicy("bob", "data1", "write")
+	checkErr(t, "AddPolicy", err)
+	err = e.LoadFilteredPolicy(s.Filter("bob", "data2_admin"))
+	checkErr(t, "LoadFilteredPolicy bob data2_admin", err)
+	checkPolicy(t, e, [][]string{{"bob", "data1", "write"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func testFilteredPolicyColumns(t *testing.T, s Suite) {
+	if s.ColumnsFilter == nil {
+		t.Skip("Suite.ColumnsFilter is not set")
+	}
+	e, a := newEnforcer(t, s)
+	if _, ok := a.(persist.FilteredAdapter); !ok {
+		t.Skip("adapter is not a persist.FilteredAdapter")
+	}
+
+	// The rules on data3 are left out of the first load by the list of v1 alone.
+	for _, rule := range [][]string{{"bob", "data1", "write"}, {"bob", "data3", "write"}, {"data2_admin", "data3", "read"}} {
+		_, err := e.AddPolicy(rule[0], rule[1], rule[2])
+		checkErr(t, "AddPolicy", err)
+	}
+
+	err := e.LoadFilteredPolicy(s.ColumnsFilter([]string{"p"},
+		[]string{"bob", "data2_admin"}, []string{"data1", "data2"}, []string{"read", "write"}))
+	checkErr(t, "LoadFilteredPolicy", err)
+	checkPolicy(t, e, [][]string{{"bob", "data1", "write"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	err = e.LoadFilteredPolicy(s.ColumnsFilter([]string{"p"},
+		[]string{"bob", "data2_admin"}, []string{"data2", "data3"}, []string{"write", "delete"}))
+	checkErr(t, "LoadFilteredPolicy", err)
+	checkPolicy(t, e, [][]string{{"bob", "data2", "write"}, {"bob", "data3", "write"}, {"data2_admin", "data2", "write"}})
+
+	err = e.LoadFilteredPolicy(s.ColumnsFilter([]string{"g"}, []string{"alice"}))
+	checkErr(t, "LoadFilteredPolicy", err)
+	checkPolicy(t, e, [][]string{})
+	rules, err := e.GetGroupingPolicy()
+	checkErr(t, "GetGroupingPolicy", err)
+	if !equalPolicies(rules, [][]string{{"alice", "data2_admin"}}) {
+		t.Errorf("GroupingPolicy: %v\nsupposed to be %v", rules, [][]string{{"alice", "data2_admin"}})
+	}
+}
+
+func testUpdatePolicy(t *testing.T, s Suite) {
+	e, a := newEnforcer(t, s)
+	if _, ok := a.(persist.UpdatableAdapter); !ok {
+		t.Skip("adapter is not a persist.UpdatableAdapter")
+	}
+
+	_, err := e.UpdatePolicy([]string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	checkErr(t, "UpdatePolicy", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "write"}, {"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func testUpdatePolicies(t *testing.T, s Suite) {
+	e, a := newEnforcer(t, s)
+	if _, ok := a.(persist.UpdatableAdapter); !ok {
+		t.Skip("adapter is not a persist.UpdatableAdapter")
+	}
+
+	_, err := e.UpdatePolicies([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, [][]string{{"alice", "data1", "write"}, {"bob", "data2", "read"}})
+	checkErr(t, "UpdatePolicies", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "write"}, {"bob", "data2", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
+
+func testUpdateFilteredPolicies(t *testing.T, s Suite) {
+	e, a := newEnforcer(t, s)
+	if _, ok := a.(persist.UpdatableAdapter); !ok {
+		t.Skip("adapter is not a persist.UpdatableAdapter")
+	}
+
+	_, err := e.UpdateFilteredPolicies([][]string{{"alice", "data1", "write"}}, 0, "alice", "data1", "read")
+	checkErr(t, "UpdateFilteredPolicies alice", err)
+	_, err = e.UpdateFilteredPolicies([][]string{{"bob", "data2", "read"}}, 0, "bob", "data2", "write")
+	checkErr(t, "UpdateFilteredPolicies bob", err)
+	err = e.LoadPolicy()
+	checkErr(t, "LoadPolicy", err)
+	checkPolicy(t, e, [][]string{{"alice", "data1", "write"}, {"bob", "data2", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+}
@@ -0,0 +1,376 @@
+package bunadapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+const (
+	defaultBatchSize = 1000
+	defaultPageSize  = 10000
+	// mssqlMaxInsertRows is the maximum number of rows of a SQL Server INSERT.
+	mssqlMaxInsertRows = 1000
+)
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// insertLines writes lines into table with one multi-row INSERT per batch.
+func (a *Adapter) insertLines(ctx context.Context, db bun.IDB, table string, lines []policyLine) error {
+	batchSize := a.batchSize
+	mssql := a.db.Dialect().Name() == dialect.MSSQL
+	if mssql && batchSize > mssqlMaxInsertRows {
+		batchSize = mssqlMaxInsertRows
+	}
+
+	for start := 0; start < len(lines); start += batchSize {
+		end := start + batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		batch := lines[start:end]
+		if a.ignoreDuplicates && mssql {
+			// SQL Server cannot ignore duplicates on INSERT, so they are
+			// left out beforehand.
+			var err error
+			batch, err = a.newLines(ctx, db, batch)
+			if err != nil {
+				return err
+			}
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		query := db.NewInsert().Model(a.rules.newRows(batch)).ModelTableExpr("?", bun.Ident(table))
+		if a.ignoreDuplicates {
+			query = query.Ignore()
+		}
+		_, err := query.Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertNewLines writes lines into the table, leaving out the ones already
+// stored, and returns the lines it wrote. The INSERT itself tells which rows
+// are new, so that a rule stored concurrently is not reported as new: they are
+// returned by RETURNING on PostgreSQL and SQLite and counted row by row on
+// MySQL. SQL Server cannot ignore duplicates on INSERT, so a rule stored
+// concurrently makes it fail with ErrDuplicateRule instead.
+func (a *Adapter) insertNewLines(ctx context.Context, db bun.IDB, lines []policyLine) ([]policyLine, error) {
+	inserted := make([]policyLine, 0, len(lines))
+	switch a.db.Dialect().Name() {
+	case dialect.PG, dialect.SQLite:
+		for start := 0; start < len(lines); start += a.batchSize {
+			end := start + a.batchSize
+			if end > len(lines) {
+				end = len(lines)
+			}
+
+			rows := a.rules.newRows(nil)
+			err := db.NewInsert().
+				Model(a.rules.newRows(lines[start:end])).
+				ModelTableExpr("?", bun.Ident(a.fullTableName())).
+				Ignore().
+				Returning(strings.Join(a.rules.columns, ", ")).
+				Scan(ctx, rows)
+			if err != nil {
+				return nil, err
+			}
+			inserted = append(inserted, a.rules.lines(rows)...)
+		}
+	case dialect.MySQL:
+		for _, line := range lines {
+			res, err := db.NewInsert().
+				Model(a.rules.newRow(line)).
+				ModelTableExpr("?", bun.Ident(a.fullTableName())).
+				Ignore().
+				Exec(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			n, err := res.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				inserted = append(inserted, line)
+			}
+		}
+	default:
+		for start := 0; start < len(lines); start += mssqlMaxInsertRows {
+			end := start + mssqlMaxInsertRows
+			if end > len(lines) {
+				end = len(lines)
+			}
+
+			_, err := db.NewInsert().
+				Model(a.rules.newRows(lines[start:end])).
+				ModelTableExpr("?", bun.Ident(a.fullTableName())).
+				Exec(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		inserted = append(inserted, lines...)
+	}
+
+	return inserted, nil
+}
+
+// newLines returns the lines that are neither stored yet nor repeated in lines.
+func (a *Adapter) newLines(ctx context.Context, db bun.IDB, lines []policyLine) ([]policyLine, error) {
+	existing, err := a.selectLines(ctx, db, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(lines))
+	for _, line := range existing {
+		seen[ruleKey(line)] = struct{}{}
+	}
+
+	missing := make([]policyLine, 0, len(lines))
+	for _, line := range lines {
+		key := ruleKey(line)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		missing = append(missing, line)
+	}
+
+	return missing, nil
+}
+
+// deleteIDs deletes the rows with the primary keys ids, batched like deleteLines.
+func (a *Adapter) deleteIDs(ctx context.Context, db bun.IDB, ids []interface{}) error {
+	for start := 0; start < len(ids); start += a.batchSize {
+		end := start + a.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		_, err := a.newDelete(db, a.fullTableName()).
+			Where("? IN (?)", bun.Ident(a.rules.pk), bun.In(ids[start:end])).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanLines reads the lines of the rows matched by the WHERE condition cond
+// page by page, in the order of the primary key, and passes every page to fn.
+// Only one page is held at a time, however large the table grows. The pages
+// follow the primary key instead of an OFFSET, which would scan all the rows
+// before it again. They are all read in one transaction, so that rules
+// written while the table is read, or a table swapped in by SaveModeSwap,
+// show up in none of them. On SQL Server that transaction uses SNAPSHOT
+// isolation, which the database has to allow with ALLOW_SNAPSHOT_ISOLATION.
+func (a *Adapter) scanLines(ctx context.Context, cond string, args []interface{}, fn func(lines []policyLine)) error {
+	// A deferred SQLite transaction holds its read lock from the first page
+	// to the last.
+	opts := &sql.TxOptions{}
+	switch a.db.Dialect().Name() {
+	case dialect.MySQL:
+		// InnoDB reads every page from the snapshot of the first one, and the
+		// RENAME of a swap waits for the transaction to end.
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	case dialect.MSSQL:
+		// Only SNAPSHOT reads every page as of the start of the transaction,
+		// without blocking writers. The driver has no read-only transactions.
+		opts = &sql.TxOptions{Isolation: sql.LevelSnapshot}
+	}
+
+	tx, err := a.db.BeginTx(ctx, opts)
+	if err != nil {
+		return a.translateError(err)
+	}
+	// The transaction only reads, so it is rolled back once the pages are read.
+	defer tx.Rollback()
+
+	// pgdriver takes no isolation level in the options.
+	if a.db.Dialect().Name() == dialect.PG {
+		_, err = tx.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY")
+		if err != nil {
+			return a.translateError(err)
+		}
+	}
+
+	var last interface{}
+	for {
+		rows := a.rules.newRows(nil)
+		query := tx.NewSelect().
+			Model(rows).
+			ModelTableExpr("? AS r", bun.Ident(a.fullTableName())).
+			Where(cond, args...).
+			OrderExpr("? ASC", bun.Ident(a.rules.pk)).
+			Limit(a.pageSize)
+		if last != nil {
+			query = query.Where("? > ?", bun.Ident(a.rules.pk), last)
+		}
+
+		err = query.Scan(ctx)
+		if err != nil {
+			return a.translateError(err)
+		}
+
+		fn(a.rules.lines(rows))
+
+		ids := a.rules.ids(rows)
+		if len(ids) < a.pageSize {
+			return nil
+		}
+		last = ids[len(ids)-1]
+	}
+}
+
+// selectLines returns the rows of the table that match lines, batched like deleteLines.
+func (a *Adapter) selectLines(ctx context.Context, db bun.IDB, lines []policyLine) ([]policyLine, error) {
+	existing := make([]policyLine, 0)
+	for start := 0; start < len(lines); start += a.batchSize {
+		end := start + a.batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		rows := a.rules.newRows(nil)
+		cond, args := a.linesCondition(lines[start:end])
+		err := db.NewSelect().
+			Model(rows).
+			ModelTableExpr("? AS r", bun.Ident(a.fullTableName())).
+			Where(cond, args...).
+			Scan(ctx)
+		if err != nil {
+			return nil, err
+		}
+		existing = append(existing, a.rules.lines(rows)...)
+	}
+
+	return existing, nil
+}
+
+// deleteLines deletes lines from the table with one row-value IN list per
+// batch and returns how many rows were removed.
+func (a *Adapter) deleteLines(ctx context.Context, db bun.IDB, lines []policyLine) (int64, error) {
+	var removed int64
+	for start := 0; start < len(lines); start += a.batchSize {
+		end := start + a.batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		cond, args := a.linesCondition(lines[start:end])
+		res, err := a.newDelete(db, a.fullTableName()).
+			Where(cond, args...).
+			Exec(ctx)
+		if err != nil {
+			return removed, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+
+	return removed, nil
+}
+
+// linesCondition returns a condition matching the rows equal to one of lines,
+// with a row-value IN list or, as SQL Server has no row values, with one
+// comparison per line.
+func (a *Adapter) linesCondition(lines []policyLine) (string, []interface{}) {
+	if a.db.Dialect().Name() != dialect.MSSQL {
+		return fmt.Sprintf("(%s) IN (?)", strings.Join(a.rules.columns, ", ")), []interface{}{bun.In(lineValues(lines))}
+	}
+
+	clauseSlice := make([]string, 0, len(lines))
+	args := make([]interface{}, 0, len(lines)*len(a.rules.columns))
+	for _, line := range lines {
+		clause, lineArgs := a.genWhereCondition(line)
+		clauseSlice = append(clauseSlice, "("+clause+")")
+		args = append(args, lineArgs...)
+	}
+
+	return strings.Join(clauseSlice, " OR "), args
+}
+
+func lineValues(lines []policyLine) [][]string {
+	values := make([][]string, 0, len(lines))
+	for _, line := range lines {
+		values = append(values, line)
+	}
+
+	return values
+}
+
+// canCopy reports whether lines can be written with COPY FROM, which needs
+// pgdriver, cannot skip duplicate rules and bypasses the hooks of a custom
+// rule model.
+func (a *Adapter) canCopy() bool {
+	if a.ignoreDuplicates || a.customModel != nil {
+		return false
+	}
+
+	_, ok := a.db.Driver().(pgdriver.Driver)
+	return ok
+}
+
+// copyLines writes lines into table with a single COPY FROM statement, so
+// either all of them are written or none.
+func (a *Adapter) copyLines(ctx context.Context, table string, lines []policyLine) error {
+	conn, err := a.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var sb strings.Builder
+	for _, line := range lines {
+		for i, val := range line {
+			if i > 0 {
+				sb.WriteByte('\t')
+			}
+			_, _ = copyEscaper.WriteString(&sb, val)
+		}
+		sb.WriteByte('\n')
+	}
+
+	query := a.db.Formatter().FormatQuery(
+		fmt.Sprintf("COPY ? (%s) FROM STDIN", strings.Join(a.rules.columns, ", ")), bun.Ident(table))
+	_, err = pgdriver.CopyFrom(ctx, conn, strings.NewReader(sb.String()), query)
+
+	return err
+}
+
+// writeLines writes lines into table outside of a transaction, with COPY FROM
+// when possible and batched INSERTs otherwise.
+func (a *Adapter) writeLines(ctx context.Context, table string, lines []policyLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if a.canCopy() {
+		return a.copyLines(ctx, table, lines)
+	}
+
+	return a.db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		return a.insertLines(ctx, tx, table, lines)
+	})
+}
@@ -0,0 +1,99 @@
+package bunadapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/v2/constant"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// roleDomainIndex is the index of the domain in the rules of a role
+// definition with a domain, like g = _, _, _.
+const roleDomainIndex = 2
+
+// DomainIndexes returns the index of the domain value in the rules of every
+// ptype of m that has one. A policy definition has a domain if it has a dom
+// token, like p = sub, dom, obj, act, or a dom field index set with
+// SetFieldIndex, and a role definition if it has three or more fields.
+func DomainIndexes(m model.Model) map[string]int {
+	indexes := make(map[string]int)
+	for ptype := range m["p"] {
+		if i, err := m.GetFieldIndex(ptype, constant.DomainIndex); err == nil {
+			indexes[ptype] = i
+		}
+	}
+	for ptype, ast := range m["g"] {
+		if len(ast.Tokens) > roleDomainIndex {
+			indexes[ptype] = roleDomainIndex
+		}
+	}
+
+	return indexes
+}
+
+// FilterByDomain returns a filter for LoadFilteredPolicy that loads the rules
+// of domains, finding the column that holds the domain of each ptype with
+// DomainIndexes. The rules of the ptypes without a domain, like the ones of
+// g2 = _, _, are loaded whole.
+func FilterByDomain(m model.Model, domains ...string) (PtypeFilter, error) {
+	indexes := DomainIndexes(m)
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("%w: the model has no domain", ErrInvalidFilter)
+	}
+
+	filter := make(PtypeFilter)
+	for _, sec := range []string{"p", "g"} {
+		for ptype := range m[sec] {
+			i, ok := indexes[ptype]
+			if !ok {
+				filter[ptype] = nil
+				continue
+			}
+			filter[ptype] = In(fmt.Sprintf("v%d", i), domains...)
+		}
+	}
+
+	return filter, nil
+}
+
+// UnloadDomains removes the rules of domains from m, undoing
+// LoadIncrementalFilteredPolicy with a filter of FilterByDomain. Only the rules
+// of the ptypes with a domain, see DomainIndexes, are removed: the ones of the
+// others, like g2 = _, _, are shared by the domains and stay loaded. The rules
+// are removed from m only, whether or not they are still stored. The enforcer
+// has to rebuild its role links with BuildRoleLinks afterwards.
+func UnloadDomains(m model.Model, domains ...string) error {
+	indexes := DomainIndexes(m)
+	if len(indexes) == 0 {
+		return fmt.Errorf("%w: the model has no domain", ErrInvalidFilter)
+	}
+
+	unload := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		unload[domain] = struct{}{}
+	}
+	for ptype, i := range indexes {
+		ast := m[ptype[:1]][ptype]
+		policy := make([][]string, 0, len(ast.Policy))
+		for _, rule := range ast.Policy {
+			if i < len(rule) {
+				if _, ok := unload[rule[i]]; ok {
+					continue
+				}
+			}
+			policy = append(policy, rule)
+		}
+		if len(policy) == len(ast.Policy) {
+			continue
+		}
+
+		ast.Policy = policy
+		ast.PolicyMap = make(map[string]int, len(policy))
+		for j, rule := range policy {
+			ast.PolicyMap[strings.Join(rule, model.DefaultSep)] = j
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package bunadapter
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/microsoft/go-mssqldb"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/mssqldialect"
+	"github.com/uptrace/bun/dialect/mysqldialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/driver/sqliteshim"
+)
+
+// NewAdapterFromDSN opens a database with the bun dialect and driver matching
+// driverName and creates an adapter that owns it, so Close closes the database.
+//
+// Supported driver names are mysql, postgres (or pg), sqlite (or sqlite3) and
+// sqlserver (or mssql).
+func NewAdapterFromDSN(driverName, dsn string, opts ...Option) (*Adapter, error) {
+	db, err := openDB(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newAdapter(db, true, opts...)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func openDB(driverName, dsn string) (*bun.DB, error) {
+	switch driverName {
+	case "mysql":
+		sqldb, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, err
+		}
+		return bun.NewDB(sqldb, mysqldialect.New()), nil
+	case "postgres", "pg":
+		sqldb := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dsn)))
+		return bun.NewDB(sqldb, pgdialect.New()), nil
+	case "sqlite", "sqlite3":
+		sqldb, err := sql.Open(sqliteshim.ShimName, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return bun.NewDB(sqldb, sqlitedialect.New()), nil
+	case "sqlserver", "mssql":
+		sqldb, err := sql.Open("sqlserver", dsn)
+		if err != nil {
+			return nil, err
+		}
+		return bun.NewDB(sqldb, mssqldialect.New()), nil
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", driverName)
+	}
+}
@@ -0,0 +1,144 @@
+package bunadapter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+var (
+	// ErrDuplicateRule is returned when a rule being stored already exists.
+	ErrDuplicateRule = errors.New("rule already exists")
+	// ErrRuleNotFound is returned when a rule being removed or updated does not exist.
+	ErrRuleNotFound = errors.New("rule not found")
+	// ErrTableExists is returned when the table or one of its indexes already exists.
+	ErrTableExists = errors.New("table already exists")
+	// ErrRuleTooLong is returned when a rule value does not fit into its column.
+	ErrRuleTooLong = errors.New("rule value too long")
+	// ErrTooManyFields is returned when a rule or filter has more values than
+	// the table has value columns.
+	ErrTooManyFields = errors.New("rule has too many fields")
+	// ErrInvalidFilter is returned when LoadFilteredPolicy gets a filter of an
+	// unknown type or a malformed predicate.
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// mssqlError is implemented by the errors of the SQL Server drivers.
+type mssqlError interface {
+	SQLErrorNumber() int32
+}
+
+// sqlStateError is implemented by the errors of the PostgreSQL drivers other
+// than pgdriver, like the *pgconn.PgError of pgx.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// translateError wraps the native error of every supported dialect into the
+// matching sentinel error, so that errors.Is works on it. The native error
+// stays in the chain for callers that need the details.
+func (a *Adapter) translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var sentinel error
+
+	var pgErr pgdriver.Error
+	var mysqlErr *mysql.MySQLError
+	var mssqlErr mssqlError
+	var stateErr sqlStateError
+	switch {
+	case errors.As(err, &pgErr):
+		sentinel = pgSentinel(pgErr.Field('C'))
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case 1062:
+			sentinel = ErrDuplicateRule
+		case 1050:
+			sentinel = ErrTableExists
+		case 1406:
+			sentinel = ErrRuleTooLong
+		}
+	case errors.As(err, &mssqlErr):
+		switch mssqlErr.SQLErrorNumber() {
+		case 2627, 2601:
+			sentinel = ErrDuplicateRule
+		case 2714:
+			sentinel = ErrTableExists
+		case 8152, 2628:
+			sentinel = ErrRuleTooLong
+		}
+	case errors.As(err, &stateErr):
+		sentinel = pgSentinel(stateErr.SQLState())
+	case a.db.Dialect().Name() == dialect.SQLite:
+		// The SQLite drivers only differ in their error types, not in their messages.
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "UNIQUE constraint failed"):
+			sentinel = ErrDuplicateRule
+		case strings.Contains(msg, "already exists"):
+			sentinel = ErrTableExists
+		}
+	}
+
+	if sentinel == nil || errors.Is(err, sentinel) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// pgSentinel returns the sentinel error of the PostgreSQL error code, if any.
+func pgSentinel(code string) error {
+	switch code {
+	case "23505":
+		return ErrDuplicateRule
+	case "42P07":
+		return ErrTableExists
+	case "22001":
+		return ErrRuleTooLong
+	}
+
+	return nil
+}
+
+// checkLines returns ErrTooManyFields if a line has more values than the table
+// has columns and ErrRuleTooLong if a value does not fit into its column. It
+// catches what SQLite does not enforce and what MySQL silently truncates
+// outside of strict mode.
+func (a *Adapter) checkLines(lines ...policyLine) error {
+	for _, line := range lines {
+		if len(line) > len(a.rules.columns) {
+			return fmt.Errorf("%w: %d values for %d value columns", ErrTooManyFields, len(line)-1, a.fieldCount)
+		}
+		for i, val := range line {
+			width := a.rules.widths[i]
+			if width > 0 && utf8.RuneCountInString(val) > width {
+				return fmt.Errorf("%w: %q exceeds %d characters", ErrRuleTooLong, val, width)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkAffected returns ErrRuleNotFound if the statement behind res did not
+// touch any row.
+func checkAffected(res sql.Result, rule []string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %v", ErrRuleNotFound, rule)
+	}
+
+	return nil
+}
@@ -0,0 +1,382 @@
+package bunadapter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// Op is the operator a Predicate matches a column with.
+type Op int
+
+const (
+	// OpEq matches the column equal to the single value.
+	OpEq Op = iota
+	// OpIn matches the column equal to any of the values.
+	OpIn
+	// OpNotIn matches the column equal to none of the values.
+	OpNotIn
+	// OpPrefix matches the column starting with the single value.
+	OpPrefix
+	// OpLike matches the column against the single value, a pattern where %
+	// matches any run of characters, _ matches one character and a backslash
+	// makes the character after it match itself.
+	OpLike
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpEq:
+		return "eq"
+	case OpIn:
+		return "in"
+	case OpNotIn:
+		return "not-in"
+	case OpPrefix:
+		return "prefix"
+	case OpLike:
+		return "like"
+	}
+
+	return "Op(" + strconv.Itoa(int(op)) + ")"
+}
+
+// likeEscape is the escape character of the LIKE patterns the adapter writes.
+// A backslash is not used as MySQL reads it as an escape in string literals.
+const likeEscape = '!'
+
+// Predicate matches one column of the table, ptype, v0, v1 and so on.
+// Prefix and pattern matching follow the case rules of LIKE in the database,
+// which ignores case in MySQL and, for ASCII letters, in SQLite.
+type Predicate struct {
+	Column string
+	Op     Op
+	Values []string
+}
+
+// Eq matches the rules whose column equals value.
+func Eq(column, value string) Predicate {
+	return Predicate{Column: column, Op: OpEq, Values: []string{value}}
+}
+
+// In matches the rules whose column equals any of values, no rule if there
+// are none.
+func In(column string, values ...string) Predicate {
+	return Predicate{Column: column, Op: OpIn, Values: values}
+}
+
+// NotIn matches the rules whose column equals none of values, every rule if
+// there are none.
+func NotIn(column string, values ...string) Predicate {
+	return Predicate{Column: column, Op: OpNotIn, Values: values}
+}
+
+// Prefix matches the rules whose column starts with prefix.
+func Prefix(column, prefix string) Predicate {
+	return Predicate{Column: column, Op: OpPrefix, Values: []string{prefix}}
+}
+
+// Like matches the rules whose column matches pattern, see OpLike.
+func Like(column, pattern string) Predicate {
+	return Predicate{Column: column, Op: OpLike, Values: []string{pattern}}
+}
+
+// Predicates is a filter for LoadFilteredPolicy that loads the rules matched
+// by every predicate.
+type Predicates []Predicate
+
+// Expr is a filter for LoadFilteredPolicy that combines predicates with And,
+// Or and Not. Predicate, Predicates and *Filter are expressions too.
+type Expr interface {
+	// condition returns the WHERE condition of the rules the expression matches.
+	condition(a *Adapter) (string, []interface{}, error)
+}
+
+type andExpr []Expr
+
+type orExpr []Expr
+
+type notExpr struct {
+	expr Expr
+}
+
+// And matches the rules every expression matches, every rule if there are none.
+func And(exprs ...Expr) Expr {
+	return andExpr(exprs)
+}
+
+// Or matches the rules any of the expressions matches, no rule if there are none.
+func Or(exprs ...Expr) Expr {
+	return orExpr(exprs)
+}
+
+// Not matches the rules expr does not match.
+func Not(expr Expr) Expr {
+	return notExpr{expr}
+}
+
+func (p Predicate) condition(a *Adapter) (string, []interface{}, error) {
+	return a.predicateCondition(p)
+}
+
+func (ps Predicates) condition(a *Adapter) (string, []interface{}, error) {
+	exprs := make(andExpr, 0, len(ps))
+	for _, p := range ps {
+		exprs = append(exprs, p)
+	}
+
+	return exprs.condition(a)
+}
+
+func (f *Filter) condition(a *Adapter) (string, []interface{}, error) {
+	predicates, err := a.filterPredicates(f)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return predicates.condition(a)
+}
+
+func (e andExpr) condition(a *Adapter) (string, []interface{}, error) {
+	return joinConditions(a, e, " AND ", "1 = 1")
+}
+
+func (e orExpr) condition(a *Adapter) (string, []interface{}, error) {
+	return joinConditions(a, e, " OR ", "1 = 0")
+}
+
+func (e notExpr) condition(a *Adapter) (string, []interface{}, error) {
+	if e.expr == nil {
+		return "", nil, fmt.Errorf("%w: nil expression", ErrInvalidFilter)
+	}
+
+	clause, args, err := e.expr.condition(a)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "NOT (" + clause + ")", args, nil
+}
+
+// joinConditions joins the conditions of exprs with op, each in parentheses,
+// and returns empty if there are no exprs.
+func joinConditions(a *Adapter, exprs []Expr, op, empty string) (string, []interface{}, error) {
+	if len(exprs) == 0 {
+		return empty, nil, nil
+	}
+
+	clauses := make([]string, 0, len(exprs))
+	var args []interface{}
+	for _, expr := range exprs {
+		if expr == nil {
+			return "", nil, fmt.Errorf("%w: nil expression", ErrInvalidFilter)
+		}
+		clause, exprArgs, err := expr.condition(a)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, "("+clause+")")
+		args = append(args, exprArgs...)
+	}
+
+	return strings.Join(clauses, op), args, nil
+}
+
+// PtypeFilter is a filter for LoadFilteredPolicy that filters the rules of each
+// ptype with its own expression, like the p rules by their domain in v1 and
+// the g rules by theirs in v2, all in one query. It loads no rules of the
+// ptypes it does not hold and every rule of the ones mapped to nil.
+type PtypeFilter map[string]Expr
+
+func (f PtypeFilter) condition(a *Adapter) (string, []interface{}, error) {
+	ptypes := make([]string, 0, len(f))
+	for ptype := range f {
+		ptypes = append(ptypes, ptype)
+	}
+	sort.Strings(ptypes)
+
+	exprs := make(orExpr, 0, len(ptypes))
+	for _, ptype := range ptypes {
+		if f[ptype] == nil {
+			exprs = append(exprs, Eq("ptype", ptype))
+			continue
+		}
+		exprs = append(exprs, And(Eq("ptype", ptype), f[ptype]))
+	}
+
+	return exprs.condition(a)
+}
+
+// filterPredicates returns the predicates matching the rules filter matches.
+func (a *Adapter) filterPredicates(filter *Filter) (Predicates, error) {
+	values := make(map[int][]string, len(filter.Values)+6)
+	for i, val := range [][]string{filter.V0, filter.V1, filter.V2, filter.V3, filter.V4, filter.V5} {
+		if len(val) > 0 {
+			values[i] = val
+		}
+	}
+	for i, val := range filter.Values {
+		if len(val) > 0 {
+			values[i] = val
+		}
+	}
+
+	indexes := make([]int, 0, len(values))
+	for i := range values {
+		if i < 0 || i >= a.fieldCount {
+			return nil, fmt.Errorf("%w: filter on v%d, the table has %d value columns", ErrTooManyFields, i, a.fieldCount)
+		}
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	columns := []string{"ptype"}
+	vals := [][]string{filter.Ptype}
+	for _, i := range indexes {
+		columns = append(columns, a.rules.columns[i+1])
+		vals = append(vals, values[i])
+	}
+
+	predicates := make(Predicates, 0, len(columns))
+	for i, col := range columns {
+		switch len(vals[i]) {
+		case 0:
+			continue
+		case 1:
+			predicates = append(predicates, Eq(col, vals[i][0]))
+		default:
+			predicates = append(predicates, In(col, vals[i]...))
+		}
+	}
+
+	return predicates, nil
+}
+
+// filterCondition returns the WHERE condition of the rules filter matches.
+func (a *Adapter) filterCondition(filter interface{}) (string, []interface{}, error) {
+	switch f := filter.(type) {
+	case []Predicate:
+		return Predicates(f).condition(a)
+	case Expr:
+		return f.condition(a)
+	}
+
+	return "", nil, fmt.Errorf("%w type %T", ErrInvalidFilter, filter)
+}
+
+func (a *Adapter) predicateCondition(p Predicate) (string, []interface{}, error) {
+	col, err := a.filterColumn(p.Column)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch p.Op {
+	case OpIn, OpNotIn:
+		if len(p.Values) == 0 {
+			if p.Op == OpIn {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		op := "IN"
+		if p.Op == OpNotIn {
+			op = "NOT IN"
+		}
+		return "? " + op + " (?)", []interface{}{col, bun.In(p.Values)}, nil
+	case OpEq, OpPrefix, OpLike:
+		if len(p.Values) != 1 {
+			return "", nil, fmt.Errorf("%w: %s on %s takes one value, got %d", ErrInvalidFilter, p.Op, p.Column, len(p.Values))
+		}
+	default:
+		return "", nil, fmt.Errorf("%w: unknown operator %s", ErrInvalidFilter, p.Op)
+	}
+
+	value := p.Values[0]
+	switch p.Op {
+	case OpPrefix:
+		value = a.escapeLike(value) + "%"
+	case OpLike:
+		value = a.likePattern(value)
+	default:
+		return "? = ?", []interface{}{col, value}, nil
+	}
+
+	return "? LIKE ? ESCAPE '" + string(likeEscape) + "'", []interface{}{col, value}, nil
+}
+
+// filterColumn returns the identifier of column, ptype or a value column of
+// the table.
+func (a *Adapter) filterColumn(column string) (bun.Ident, error) {
+	column = strings.ToLower(column)
+	for _, col := range a.rules.columns {
+		if col == column {
+			return bun.Ident(col), nil
+		}
+	}
+
+	if n, ok := strings.CutPrefix(column, "v"); ok {
+		if _, err := strconv.ParseUint(n, 10, 0); err == nil {
+			return "", fmt.Errorf("%w: filter on %s, the table has %d value columns", ErrTooManyFields, column, a.fieldCount)
+		}
+	}
+
+	return "", fmt.Errorf("%w: unknown column %q", ErrInvalidFilter, column)
+}
+
+// isLikeSpecial reports whether c has to be escaped to match itself in a
+// LIKE pattern. SQL Server also reads [ as the start of a character class.
+func (a *Adapter) isLikeSpecial(c rune) bool {
+	switch c {
+	case '%', '_', likeEscape:
+		return true
+	case '[':
+		return a.db.Dialect().Name() == dialect.MSSQL
+	}
+
+	return false
+}
+
+// escapeLike returns a LIKE pattern matching s itself.
+func (a *Adapter) escapeLike(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if a.isLikeSpecial(c) {
+			b.WriteRune(likeEscape)
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// likePattern translates pattern, with the syntax of OpLike, into a LIKE
+// pattern escaped with likeEscape.
+func (a *Adapter) likePattern(pattern string) string {
+	var b strings.Builder
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+			continue
+		case c == '%' || c == '_':
+			b.WriteRune(c)
+			continue
+		}
+		if a.isLikeSpecial(c) {
+			b.WriteRune(likeEscape)
+		}
+		b.WriteRune(c)
+	}
+	if escaped {
+		b.WriteRune('\\')
+	}
+
+	return b.String()
+}
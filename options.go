@@ -0,0 +1,156 @@
+package bunadapter
+
+import "context"
+
+const defaultColumnWidth = 100
+
+// Logger receives the errors the adapter cannot return to its caller.
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Option configures an Adapter created by NewAdapterWithOptions.
+type Option func(a *Adapter)
+
+// WithContext sets the context used for the queries of the adapter.
+func WithContext(ctx context.Context) Option {
+	return func(a *Adapter) {
+		a.ctx = ctx
+	}
+}
+
+// WithTableName sets the table name, casbin_rule by default. A name like
+// authz.rules sets the schema as well, unless WithSchema is given. Names may
+// only hold letters, digits, underscores and dollar signs and are quoted in
+// every query, so their case is kept.
+func WithTableName(tableName string) Option {
+	return func(a *Adapter) {
+		a.tableName = tableName
+	}
+}
+
+// WithSchema sets the schema the table lives in.
+func WithSchema(schemaName string) Option {
+	return func(a *Adapter) {
+		a.schemaName = schemaName
+	}
+}
+
+// WithAutoCreateTable sets whether the table is created if it does not exist, true by default.
+func WithAutoCreateTable(autoCreate bool) Option {
+	return func(a *Adapter) {
+		a.autoCreate = autoCreate
+	}
+}
+
+// WithFinalizer sets whether a finalizer closes the connection the adapter
+// opened itself once the adapter is garbage-collected, true by default.
+func WithFinalizer(finalizer bool) Option {
+	return func(a *Adapter) {
+		a.finalizer = finalizer
+	}
+}
+
+// WithLogger sets the logger, log.Default() by default.
+func WithLogger(logger Logger) Option {
+	return func(a *Adapter) {
+		a.logger = logger
+	}
+}
+
+// WithColumnWidth sets the VARCHAR width of the ptype and value columns, 100 by default.
+func WithColumnWidth(width int) Option {
+	return func(a *Adapter) {
+		if width > 0 {
+			a.columnWidth = width
+		}
+	}
+}
+
+// WithSaveMode sets how SavePolicy writes the policy, SaveModeDiff by default.
+func WithSaveMode(mode SaveMode) Option {
+	return func(a *Adapter) {
+		a.saveMode = mode
+	}
+}
+
+// WithBatchSize sets how many rules a single INSERT or DELETE handles, 1000 by default.
+// On PostgreSQL with pgdriver, bulk writes use COPY FROM instead.
+func WithBatchSize(size int) Option {
+	return func(a *Adapter) {
+		if size > 0 {
+			a.batchSize = size
+		}
+	}
+}
+
+// WithPageSize sets how many rules LoadPolicy and LoadFilteredPolicy read per
+// query, 10000 by default. They page through the table by primary key in one
+// transaction, so that loading holds a single page in memory besides the model
+// and still sees the table at one point in time. On SQL Server the loads need
+// ALTER DATABASE ... SET ALLOW_SNAPSHOT_ISOLATION ON for that.
+func WithPageSize(size int) Option {
+	return func(a *Adapter) {
+		if size > 0 {
+			a.pageSize = size
+		}
+	}
+}
+
+// WithIgnoreDuplicates makes adding a rule that is already stored a no-op
+// instead of a duplicate key error, with ON CONFLICT DO NOTHING on PostgreSQL
+// and SQLite and INSERT IGNORE on MySQL. Off by default.
+func WithIgnoreDuplicates(ignore bool) Option {
+	return func(a *Adapter) {
+		a.ignoreDuplicates = ignore
+	}
+}
+
+// WithStrictUpdates makes UpdatePolicies roll back all of its updates and
+// return ErrRuleNotFound when one of the old rules is not stored. Otherwise
+// the rules that were found are updated, the missing ones are logged and no
+// error is returned, so that the enforcer applies the updates to its model as
+// well. Off by default.
+func WithStrictUpdates(strict bool) Option {
+	return func(a *Adapter) {
+		a.strictUpdates = strict
+	}
+}
+
+// WithFieldCount sets how many value columns (v0, v1, ...) the table has,
+// 6 by default. Rules with more values are rejected with ErrTooManyFields.
+func WithFieldCount(n int) Option {
+	return func(a *Adapter) {
+		if n > 0 {
+			a.fieldCount = n
+		}
+	}
+}
+
+// WithColumnType sets the SQL type of column, which is ptype, v0, v1 and so
+// on, for example TEXT or varchar(1024). Columns without a type are varchars
+// of WithColumnWidth characters, nvarchars on SQL Server, where varchar(max)
+// takes the place of TEXT. On MySQL and SQL Server the unique index covers a
+// generated hash of the columns when they are too long to be indexed directly.
+// NewAdapterWithOptions fails for a column the table does not have.
+func WithColumnType(column, sqlType string) Option {
+	return func(a *Adapter) {
+		if a.columnTypes == nil {
+			a.columnTypes = make(map[string]string)
+		}
+		a.columnTypes[column] = sqlType
+	}
+}
+
+// WithRuleModel makes the adapter store rules in a custom model instead of
+// CasbinRule, passed as a nil pointer like (*MyRule)(nil). The model needs a
+// single primary key, a ptype column and value columns named v0, v1 and so on,
+// and may have any other columns. Its table name is the default table name
+// and its value columns replace WithFieldCount and WithColumnType. Bun hooks
+// of the model run as usual, so rules are never written with COPY FROM.
+func WithRuleModel(model Rule) Option {
+	return func(a *Adapter) {
+		a.customModel = model
+	}
+}
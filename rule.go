@@ -0,0 +1,243 @@
+package bunadapter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+const defaultFieldCount = 6
+
+var charTypeRegexp = regexp.MustCompile(`(?i)^\s*(?:n?var)?char\s*\(\s*(\d+)\s*\)\s*$`)
+
+// Rule is implemented by the models the adapter stores rules in, CasbinRule
+// and the custom models passed to WithRuleModel. It maps a row to the ptype
+// and the values of a rule, which live in the ptype, v0, v1, ... columns.
+type Rule interface {
+	// Policy returns the ptype and the values of the rule.
+	Policy() (ptype string, values []string)
+	// SetPolicy sets the ptype and the values of the rule. values holds one
+	// value per value column, empty for the ones the rule does not use.
+	SetPolicy(ptype string, values []string)
+}
+
+var ruleInterface = reflect.TypeOf((*Rule)(nil)).Elem()
+
+// Policy returns the ptype and the values of the rule.
+func (r *CasbinRule) Policy() (string, []string) {
+	return r.Ptype, []string{r.V0, r.V1, r.V2, r.V3, r.V4, r.V5}
+}
+
+// SetPolicy sets the ptype and the values of the rule.
+func (r *CasbinRule) SetPolicy(ptype string, values []string) {
+	v := make([]string, defaultFieldCount)
+	copy(v, values)
+	r.Ptype, r.V0, r.V1, r.V2, r.V3, r.V4, r.V5 = ptype, v[0], v[1], v[2], v[3], v[4], v[5]
+}
+
+// policyLine is a rule as it is stored in the table: the ptype followed by
+// the values of v0, v1 and so on.
+type policyLine []string
+
+// ruleModel maps policy lines to the rows of the bun model behind the table,
+// through Rule if the model implements it and through its fields otherwise.
+type ruleModel struct {
+	typ    reflect.Type
+	isRule bool
+	// softDelete is set if the model has a bun soft delete field.
+	softDelete bool
+	pk         string
+	id         []int
+	columns    []string
+	fields     [][]int
+	// widths holds the number of characters each column takes, 0 if it is
+	// not limited.
+	widths []int
+}
+
+// columnNames returns ptype and the names of n value columns.
+func columnNames(n int) []string {
+	columns := make([]string, 0, n+1)
+	columns = append(columns, "ptype")
+	for i := 0; i < n; i++ {
+		columns = append(columns, fmt.Sprintf("v%d", i))
+	}
+
+	return columns
+}
+
+// sqlTypeWidth returns the number of characters a column of sqlType takes,
+// width for an untyped column and 0 for a type it cannot tell, like TEXT.
+func sqlTypeWidth(sqlType string, width int) int {
+	if sqlType == "" || strings.EqualFold(sqlType, "varchar") {
+		return width
+	}
+
+	match := charTypeRegexp.FindStringSubmatch(sqlType)
+	if match == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// valueColumnCount returns how many value columns, starting at v0, table has.
+func valueColumnCount(table *schema.Table) int {
+	n := 0
+	for table.HasField(fmt.Sprintf("v%d", n)) {
+		n++
+	}
+
+	return n
+}
+
+// ruleType returns CasbinRule for six plain value columns and otherwise a
+// struct type shaped like CasbinRule with n value columns of the SQL types in
+// columnTypes. Columns with a SQL type have no default, as MySQL does not
+// allow one for TEXT, so empty values are written instead of left out.
+func ruleType(n int, columnTypes map[string]string) reflect.Type {
+	base := reflect.TypeOf(CasbinRule{})
+	if n == defaultFieldCount && len(columnTypes) == 0 {
+		return base
+	}
+
+	// BaseModel and Id are kept as they are.
+	fields := []reflect.StructField{base.Field(0), base.Field(1)}
+	for i, col := range columnNames(n) {
+		opts := []string{col, "nullzero", "notnull", "default:''"}
+		if sqlType := columnTypes[col]; sqlType != "" {
+			opts = []string{col, "notnull", "type:" + sqlType}
+		}
+
+		name := "Ptype"
+		if i > 0 {
+			name = fmt.Sprintf("V%d", i-1)
+		}
+		fields = append(fields, reflect.StructField{
+			Name: name,
+			Type: reflect.TypeOf(""),
+			Tag:  reflect.StructTag(fmt.Sprintf(`bun:"%s"`, strings.Join(opts, ","))),
+		})
+	}
+
+	return reflect.StructOf(fields)
+}
+
+func newRuleModel(db *bun.DB, typ reflect.Type, n, width int) (*ruleModel, error) {
+	table := db.Table(typ)
+	if len(table.PKs) != 1 {
+		return nil, fmt.Errorf("%s must have a single primary key", typ)
+	}
+
+	m := &ruleModel{
+		typ:        typ,
+		isRule:     reflect.PtrTo(typ).Implements(ruleInterface),
+		softDelete: table.SoftDeleteField != nil,
+		pk:         table.PKs[0].Name,
+		id:         table.PKs[0].Index,
+		columns:    columnNames(n),
+		fields:     make([][]int, 0, n+1),
+		widths:     make([]int, 0, n+1),
+	}
+
+	for _, col := range m.columns {
+		field, ok := table.FieldMap[col]
+		if !ok {
+			return nil, fmt.Errorf("%s has no %s column", typ, col)
+		}
+		m.fields = append(m.fields, field.Index)
+		m.widths = append(m.widths, sqlTypeWidth(field.UserSQLType, width))
+	}
+
+	return m, nil
+}
+
+// model returns a nil pointer to the model, for queries that read or write no rows.
+func (m *ruleModel) model() interface{} {
+	return reflect.Zero(reflect.PtrTo(m.typ)).Interface()
+}
+
+// newRow returns a pointer to a row holding line.
+func (m *ruleModel) newRow(line policyLine) interface{} {
+	row := reflect.New(m.typ)
+	m.setLine(row, line)
+
+	return row.Interface()
+}
+
+// newRows returns a pointer to a slice of rows holding lines, ready to be
+// passed to Model.
+func (m *ruleModel) newRows(lines []policyLine) interface{} {
+	rows := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(m.typ)), 0, len(lines))
+	for _, line := range lines {
+		row := reflect.New(m.typ)
+		m.setLine(row, line)
+		rows = reflect.Append(rows, row)
+	}
+
+	ptr := reflect.New(rows.Type())
+	ptr.Elem().Set(rows)
+
+	return ptr.Interface()
+}
+
+// lines returns the lines held by rows, a pointer returned by newRows.
+func (m *ruleModel) lines(rows interface{}) []policyLine {
+	slice := reflect.ValueOf(rows).Elem()
+	lines := make([]policyLine, 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		lines = append(lines, m.line(slice.Index(i)))
+	}
+
+	return lines
+}
+
+// ids returns the primary keys of rows, a pointer returned by newRows.
+func (m *ruleModel) ids(rows interface{}) []interface{} {
+	slice := reflect.ValueOf(rows).Elem()
+	ids := make([]interface{}, 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		ids = append(ids, slice.Index(i).Elem().FieldByIndex(m.id).Interface())
+	}
+
+	return ids
+}
+
+// line returns the line held by row, a pointer to the model.
+func (m *ruleModel) line(row reflect.Value) policyLine {
+	line := make(policyLine, len(m.columns))
+	if m.isRule {
+		ptype, values := row.Interface().(Rule).Policy()
+		line[0] = ptype
+		copy(line[1:], values)
+		return line
+	}
+
+	for i, index := range m.fields {
+		line[i] = row.Elem().FieldByIndex(index).String()
+	}
+
+	return line
+}
+
+// setLine stores line in row, a pointer to the model.
+func (m *ruleModel) setLine(row reflect.Value, line policyLine) {
+	if m.isRule {
+		row.Interface().(Rule).SetPolicy(line[0], line[1:])
+		return
+	}
+
+	for i, index := range m.fields {
+		row.Elem().FieldByIndex(index).SetString(line[i])
+	}
+}